@@ -0,0 +1,34 @@
+package bakery
+
+import (
+	"github.com/rogpeppe/macaroon/checkers"
+)
+
+// CaveatChecker adapts a *checkers.Checker to the ThirdPartyChecker
+// interface, so that a Discharger can be built from the checkers
+// subpackage instead of a hand-written switch over conditions.
+// It adds no caveats of its own to the discharge macaroon.
+type CaveatChecker struct {
+	Checker *checkers.Checker
+}
+
+// CheckThirdPartyCaveat implements ThirdPartyChecker.
+func (c *CaveatChecker) CheckThirdPartyCaveat(caveat string) ([]Caveat, error) {
+	if err := c.Checker.Check(caveat); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// FirstPartyCaveatChecker adapts a *checkers.Checker to the
+// FirstPartyChecker interface expected by Service.NewRequest, so
+// that a Service can verify first-party caveats using the checkers
+// subpackage instead of a hand-written switch over conditions.
+type FirstPartyCaveatChecker struct {
+	Checker *checkers.Checker
+}
+
+// CheckFirstPartyCaveat implements FirstPartyChecker.
+func (c *FirstPartyCaveatChecker) CheckFirstPartyCaveat(caveat string) error {
+	return c.Checker.Check(caveat)
+}
@@ -0,0 +1,348 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// The v1 binary format is the packet-based format used by the
+// reference libmacaroons implementation. Each packet consists of a
+// 4-hex-digit length prefix (the length of the whole packet,
+// including the prefix itself) followed by "key value\n". The
+// list of caveat fields is terminated by a zero-length packet
+// before the final signature packet.
+
+const (
+	fieldLocation   = "location"
+	fieldIdentifier = "identifier"
+	fieldCID        = "cid"
+	fieldVID        = "vid"
+	fieldCL         = "cl"
+	fieldSignature  = "signature"
+)
+
+// maxPacketLen is the largest packet size that can be represented
+// by the 4-hex-digit length prefix.
+const maxPacketLen = 0xffff
+
+// MarshalBinary implements encoding.BinaryMarshaler, producing the
+// packet-based v1 binary format used by libmacaroons and other
+// macaroon implementations.
+func (m *Macaroon) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writePacket(&buf, fieldLocation, []byte(m.location)); err != nil {
+		return nil, err
+	}
+	if err := writePacket(&buf, fieldIdentifier, []byte(m.id)); err != nil {
+		return nil, err
+	}
+	for _, cav := range m.caveats {
+		if err := writePacket(&buf, fieldCID, []byte(cav.caveatId)); err != nil {
+			return nil, err
+		}
+		if cav.IsThirdParty() {
+			if err := writePacket(&buf, fieldVID, cav.verificationId); err != nil {
+				return nil, err
+			}
+			if err := writePacket(&buf, fieldCL, []byte(cav.location)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// The end packet terminates the caveat list before the signature.
+	if err := writeEndPacket(&buf); err != nil {
+		return nil, err
+	}
+	if err := writePacket(&buf, fieldSignature, m.sig); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler, reading the
+// packet-based v1 binary format produced by MarshalBinary.
+func (m *Macaroon) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var (
+		loc, id string
+		sig     []byte
+		caveats []Caveat
+		cur     *Caveat
+	)
+	flush := func() {
+		if cur != nil {
+			caveats = append(caveats, *cur)
+			cur = nil
+		}
+	}
+	for r.Len() > 0 {
+		key, value, end, err := readPacket(r)
+		if err != nil {
+			return fmt.Errorf("cannot read macaroon packet: %v", err)
+		}
+		if end {
+			flush()
+			continue
+		}
+		if len(value) > MaxFieldLen {
+			return ErrTooLarge
+		}
+		switch key {
+		case fieldLocation:
+			if cur == nil {
+				loc = string(value)
+			} else {
+				cur.location = string(value)
+			}
+		case fieldIdentifier:
+			id = string(value)
+		case fieldCID:
+			flush()
+			if len(caveats) >= MaxCaveats {
+				return ErrTooLarge
+			}
+			cur = &Caveat{caveatId: string(value)}
+		case fieldVID:
+			if cur == nil {
+				return fmt.Errorf("vid packet without preceding cid packet")
+			}
+			cur.verificationId = append([]byte(nil), value...)
+		case fieldCL:
+			if cur == nil {
+				return fmt.Errorf("cl packet without preceding cid packet")
+			}
+			cur.location = string(value)
+		case fieldSignature:
+			flush()
+			sig = append([]byte(nil), value...)
+		default:
+			return fmt.Errorf("unknown binary field %q", key)
+		}
+	}
+	if sig == nil {
+		return fmt.Errorf("no signature found in macaroon data")
+	}
+	m.location = loc
+	m.id = id
+	m.caveats = caveats
+	m.sig = sig
+	m.sigHistory = nil
+	return nil
+}
+
+// writePacket writes a single v1 packet with the given key and value.
+func writePacket(buf *bytes.Buffer, key string, value []byte) error {
+	packetLen := 4 + len(key) + 1 + len(value) + 1
+	if packetLen > maxPacketLen {
+		return fmt.Errorf("macaroon packet for field %q is too big", key)
+	}
+	fmt.Fprintf(buf, "%04x", packetLen)
+	buf.WriteString(key)
+	buf.WriteByte(' ')
+	buf.Write(value)
+	buf.WriteByte('\n')
+	return nil
+}
+
+// writeEndPacket writes the zero-length packet that terminates a
+// section of the v1 format.
+func writeEndPacket(buf *bytes.Buffer) error {
+	buf.WriteString("0000")
+	return nil
+}
+
+// readPacket reads a single v1 packet from r, returning its key and
+// value, or end==true if the packet is the zero-length terminator.
+func readPacket(r *bytes.Reader) (key string, value []byte, end bool, err error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return "", nil, false, fmt.Errorf("cannot read packet length: %v", err)
+	}
+	packetLen, err := strconv.ParseUint(string(lenBuf[:]), 16, 16)
+	if err != nil {
+		return "", nil, false, fmt.Errorf("invalid packet length %q: %v", lenBuf, err)
+	}
+	if packetLen == 0 {
+		return "", nil, true, nil
+	}
+	if int(packetLen) < 4 {
+		return "", nil, false, fmt.Errorf("packet length %d too small", packetLen)
+	}
+	body := make([]byte, int(packetLen)-4)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return "", nil, false, fmt.Errorf("cannot read packet body: %v", err)
+	}
+	if len(body) == 0 || body[len(body)-1] != '\n' {
+		return "", nil, false, fmt.Errorf("packet not terminated by newline")
+	}
+	body = body[:len(body)-1]
+	i := bytes.IndexByte(body, ' ')
+	if i == -1 {
+		return "", nil, false, fmt.Errorf("packet missing key/value separator")
+	}
+	return string(body[:i]), body[i+1:], false, nil
+}
+
+// The v2 binary format is a more compact TLV encoding. It starts
+// with a single version byte (0x02), followed by a header section
+// (location, identifier), a caveats section and a signature field.
+// Each field is encoded as a tag byte followed by a uvarint length
+// and the field data; a zero tag byte terminates a section.
+
+const (
+	version2 = 2
+
+	fieldTagEOS        = 0
+	fieldTagLocation   = 1
+	fieldTagIdentifier = 2
+	fieldTagVID        = 3
+	fieldTagCID        = 4
+	fieldTagSignature  = 6
+)
+
+// MarshalBinaryV2 implements the newer, more compact v2 binary
+// macaroon format.
+func (m *Macaroon) MarshalBinaryV2() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(version2)
+	if m.location != "" {
+		writeV2Field(&buf, fieldTagLocation, []byte(m.location))
+	}
+	writeV2Field(&buf, fieldTagIdentifier, []byte(m.id))
+	buf.WriteByte(fieldTagEOS)
+	for _, cav := range m.caveats {
+		if cav.location != "" {
+			writeV2Field(&buf, fieldTagLocation, []byte(cav.location))
+		}
+		writeV2Field(&buf, fieldTagCID, []byte(cav.caveatId))
+		if cav.IsThirdParty() {
+			writeV2Field(&buf, fieldTagVID, cav.verificationId)
+		}
+		buf.WriteByte(fieldTagEOS)
+	}
+	buf.WriteByte(fieldTagEOS)
+	writeV2Field(&buf, fieldTagSignature, m.sig)
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinaryV2 reads a macaroon previously marshaled with
+// MarshalBinaryV2.
+func (m *Macaroon) UnmarshalBinaryV2(data []byte) error {
+	if len(data) == 0 || data[0] != version2 {
+		return fmt.Errorf("unsupported macaroon binary version")
+	}
+	r := bytes.NewReader(data[1:])
+
+	loc, id, err := readV2Header(r)
+	if err != nil {
+		return err
+	}
+	caveats, err := readV2Caveats(r)
+	if err != nil {
+		return err
+	}
+	tag, sig, err := readV2Field(r)
+	if err != nil {
+		return fmt.Errorf("cannot read macaroon signature: %v", err)
+	}
+	if tag != fieldTagSignature {
+		return fmt.Errorf("expected signature field, got tag %d", tag)
+	}
+	m.location = loc
+	m.id = id
+	m.caveats = caveats
+	m.sig = sig
+	m.sigHistory = nil
+	return nil
+}
+
+func readV2Header(r *bytes.Reader) (loc, id string, err error) {
+	for {
+		tag, value, err := readV2Field(r)
+		if err != nil {
+			return "", "", fmt.Errorf("cannot read macaroon header: %v", err)
+		}
+		switch tag {
+		case fieldTagEOS:
+			return loc, id, nil
+		case fieldTagLocation:
+			loc = string(value)
+		case fieldTagIdentifier:
+			id = string(value)
+		default:
+			return "", "", fmt.Errorf("unexpected field tag %d in header", tag)
+		}
+	}
+}
+
+// readV2Caveats reads the caveats section: a sequence of caveats,
+// each terminated by an EOS field, with the whole section terminated
+// by an EOS field encountered before any fields of a new caveat have
+// been read.
+func readV2Caveats(r *bytes.Reader) ([]Caveat, error) {
+	var caveats []Caveat
+	for {
+		var cav Caveat
+		sawField := false
+		for {
+			tag, value, err := readV2Field(r)
+			if err != nil {
+				return nil, fmt.Errorf("cannot read macaroon caveat: %v", err)
+			}
+			if tag == fieldTagEOS {
+				break
+			}
+			sawField = true
+			switch tag {
+			case fieldTagLocation:
+				cav.location = string(value)
+			case fieldTagCID:
+				cav.caveatId = string(value)
+			case fieldTagVID:
+				cav.verificationId = append([]byte(nil), value...)
+			default:
+				return nil, fmt.Errorf("unexpected field tag %d in caveat", tag)
+			}
+		}
+		if !sawField {
+			return caveats, nil
+		}
+		if len(caveats) >= MaxCaveats {
+			return nil, ErrTooLarge
+		}
+		caveats = append(caveats, cav)
+	}
+}
+
+func writeV2Field(buf *bytes.Buffer, tag byte, value []byte) {
+	buf.WriteByte(tag)
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], uint64(len(value)))
+	buf.Write(lenBuf[:n])
+	buf.Write(value)
+}
+
+func readV2Field(r *bytes.Reader) (tag byte, value []byte, err error) {
+	tag, err = r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	if tag == fieldTagEOS {
+		return tag, nil, nil
+	}
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, nil, fmt.Errorf("cannot read field length: %v", err)
+	}
+	if length > uint64(MaxFieldLen) {
+		return 0, nil, fmt.Errorf("field length %d exceeds maximum of %d: %w", length, MaxFieldLen, ErrTooLarge)
+	}
+	value = make([]byte, length)
+	if _, err := io.ReadFull(r, value); err != nil {
+		return 0, nil, fmt.Errorf("cannot read field value: %v", err)
+	}
+	return tag, value, nil
+}
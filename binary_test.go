@@ -0,0 +1,87 @@
+package macaroon
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func newTestMacaroon(t *testing.T) *Macaroon {
+	t.Helper()
+	m := New([]byte("root key"), "some id", "http://example.com/")
+	m.AddFirstPartyCaveat("account = 3735928559")
+	if _, err := m.AddThirdPartyCaveat([]byte("shared secret"), "user = alice", "http://auth.example.com/"); err != nil {
+		t.Fatalf("AddThirdPartyCaveat: %v", err)
+	}
+	return m
+}
+
+func assertMacaroonsEqual(t *testing.T, m, m1 *Macaroon) {
+	t.Helper()
+	if m1.Location() != m.Location() {
+		t.Errorf("location: got %q want %q", m1.Location(), m.Location())
+	}
+	if m1.Id() != m.Id() {
+		t.Errorf("id: got %q want %q", m1.Id(), m.Id())
+	}
+	if !bytes.Equal(m1.Signature(), m.Signature()) {
+		t.Errorf("signature: got %x want %x", m1.Signature(), m.Signature())
+	}
+	if len(m1.Caveats()) != len(m.Caveats()) {
+		t.Fatalf("caveat count: got %d want %d", len(m1.Caveats()), len(m.Caveats()))
+	}
+	for i := range m.Caveats() {
+		c, c1 := m.Caveats()[i], m1.Caveats()[i]
+		if c.Id() != c1.Id() || c.Location() != c1.Location() || !bytes.Equal(c.verificationId, c1.verificationId) {
+			t.Errorf("caveat %d mismatch: %+v vs %+v", i, c, c1)
+		}
+	}
+}
+
+func TestMarshalBinaryRoundTrip(t *testing.T) {
+	m := newTestMacaroon(t)
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary: %v", err)
+	}
+	var m1 Macaroon
+	if err := m1.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	assertMacaroonsEqual(t, m, &m1)
+}
+
+func TestMarshalBinaryV2RoundTrip(t *testing.T) {
+	m := newTestMacaroon(t)
+	data, err := m.MarshalBinaryV2()
+	if err != nil {
+		t.Fatalf("MarshalBinaryV2: %v", err)
+	}
+	var m1 Macaroon
+	if err := m1.UnmarshalBinaryV2(data); err != nil {
+		t.Fatalf("UnmarshalBinaryV2: %v", err)
+	}
+	assertMacaroonsEqual(t, m, &m1)
+}
+
+// TestUnmarshalBinaryV2RejectsOversizedField guards against
+// https://github.com/cmars/macaroon - readV2Field allocating a
+// buffer sized directly from an attacker-controlled uvarint before
+// reading any data.
+func TestUnmarshalBinaryV2RejectsOversizedField(t *testing.T) {
+	saved := MaxFieldLen
+	MaxFieldLen = 8
+	defer func() { MaxFieldLen = saved }()
+
+	var buf bytes.Buffer
+	buf.WriteByte(version2)
+	buf.WriteByte(fieldTagIdentifier)
+	var lenBuf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(lenBuf[:], 1<<20) // far beyond MaxFieldLen
+	buf.Write(lenBuf[:n])
+
+	var m Macaroon
+	if err := m.UnmarshalBinaryV2(buf.Bytes()); err == nil {
+		t.Fatalf("expected an error for an oversized field, got nil")
+	}
+}
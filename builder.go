@@ -0,0 +1,125 @@
+package macaroon
+
+import "fmt"
+
+// Builder incrementally constructs a macaroon without mutating any
+// existing value: each Add method returns the Builder so calls can
+// be chained, and Build returns a new, independent ImmutableMacaroon, leaving
+// the Builder free to keep being extended.
+type Builder struct {
+	m   *Macaroon
+	err error
+}
+
+// NewBuilder returns a Builder for a new macaroon with the given
+// root key, identifier and location, as per New.
+func NewBuilder(rootKey []byte, id, loc string) *Builder {
+	return &Builder{m: New(rootKey, id, loc)}
+}
+
+// AddFirstPartyCaveat adds a caveat that will be verified by the
+// target service.
+func (b *Builder) AddFirstPartyCaveat(caveatId string) *Builder {
+	if b.err == nil {
+		b.m.addCaveat(caveatId, nil, "")
+	}
+	return b
+}
+
+// AddThirdPartyCaveat adds a third-party caveat to the macaroon,
+// using the given shared secret, caveat and location hint.
+func (b *Builder) AddThirdPartyCaveat(thirdPartySecret []byte, caveat string, loc string) *Builder {
+	if b.err == nil {
+		_, b.err = b.m.AddThirdPartyCaveat(thirdPartySecret, caveat, loc)
+	}
+	return b
+}
+
+// AddThirdPartyCaveatWithKey is like AddThirdPartyCaveat, but uses
+// public-key cryptography, as per Macaroon.AddThirdPartyCaveatWithKey.
+func (b *Builder) AddThirdPartyCaveatWithKey(theirPub *[32]byte, myKey *KeyPair, condition, loc string) *Builder {
+	if b.err == nil {
+		_, b.err = b.m.AddThirdPartyCaveatWithKey(theirPub, myKey, condition, loc)
+	}
+	return b
+}
+
+// Build returns the built macaroon as an ImmutableMacaroon.
+//
+// It panics if a previous Add call failed; in practice that can only
+// happen if the system's random number generator fails, which is not
+// something callers are expected to handle inline while chaining
+// Builder calls.
+func (b *Builder) Build() *ImmutableMacaroon {
+	if b.err != nil {
+		panic(fmt.Sprintf("macaroon: cannot build macaroon: %v", b.err))
+	}
+	return &ImmutableMacaroon{m: b.m.Clone()}
+}
+
+// ImmutableMacaroon is a macaroon built by Builder.Build. Unlike
+// *Macaroon, it exposes none of the deprecated mutating methods
+// (AddFirstPartyCaveat, AddThirdPartyCaveat, Bind), so holding one
+// doesn't risk corrupting a macaroon shared with another caller -
+// the aliasing bugs Builder and Rebind exist to avoid stay avoided
+// for the whole lifetime of the value, not just while it's built.
+type ImmutableMacaroon struct {
+	m *Macaroon
+}
+
+// Macaroon returns a mutable copy of im, for passing to APIs (such as
+// Verify's discharges map, or Macaroon.UnmarshalJSON's counterpart
+// MarshalJSON) that still deal in *Macaroon.
+func (im *ImmutableMacaroon) Macaroon() *Macaroon {
+	return im.m.Clone()
+}
+
+// Location returns the macaroon's location hint, as per Macaroon.Location.
+func (im *ImmutableMacaroon) Location() string {
+	return im.m.Location()
+}
+
+// Id returns the macaroon's id, as per Macaroon.Id.
+func (im *ImmutableMacaroon) Id() string {
+	return im.m.Id()
+}
+
+// Signature returns the macaroon's signature, as per Macaroon.Signature.
+func (im *ImmutableMacaroon) Signature() []byte {
+	return im.m.Signature()
+}
+
+// Caveats returns a copy of the macaroon's caveats, as per
+// Macaroon.Caveats.
+func (im *ImmutableMacaroon) Caveats() []Caveat {
+	return append([]Caveat(nil), im.m.Caveats()...)
+}
+
+// Rebind returns a copy of im's macaroon bound for use as a discharge
+// macaroon for the macaroon whose signature is rootSig, as per
+// Macaroon.Rebind.
+func (im *ImmutableMacaroon) Rebind(rootSig []byte) *Macaroon {
+	return im.m.Rebind(rootSig)
+}
+
+// Prepare returns a copy of im's macaroon with only its first n
+// caveats, as per Macaroon.Prepare.
+func (im *ImmutableMacaroon) Prepare(n int) (*Macaroon, error) {
+	return im.m.Prepare(n)
+}
+
+// MarshalJSON implements json.Marshaler.
+func (im *ImmutableMacaroon) MarshalJSON() ([]byte, error) {
+	return im.m.MarshalJSON()
+}
+
+// MarshalBinary implements encoding.BinaryMarshaler.
+func (im *ImmutableMacaroon) MarshalBinary() ([]byte, error) {
+	return im.m.MarshalBinary()
+}
+
+// MarshalBinaryV2 marshals im's macaroon using the v2 binary format,
+// as per Macaroon.MarshalBinaryV2.
+func (im *ImmutableMacaroon) MarshalBinaryV2() ([]byte, error) {
+	return im.m.MarshalBinaryV2()
+}
@@ -0,0 +1,97 @@
+// Package checkers provides a pluggable grammar for first-party
+// caveat conditions.
+//
+// A caveat condition has the canonical form "cond arg", where cond
+// is optionally namespaced as "ns.cond" so that checkers registered
+// by different packages don't collide. The standard checkers
+// provided by this package are namespaced under "std".
+package checkers
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StdNamespace is the namespace under which the checkers in this
+// package register their conditions.
+const StdNamespace = "std"
+
+// The standard conditions understood by the checkers registered by
+// Std.
+const (
+	CondTimeBefore = "time-before"
+	CondDeclared   = "declared"
+	CondClientIP   = "ip"
+	CondOperation  = "operation"
+)
+
+// Func is the signature of a function that checks a caveat
+// condition's argument, returning a non-nil error if the caveat is
+// not satisfied.
+type Func func(arg string) error
+
+// Checker holds a registry of condition checkers, keyed by their
+// fully-namespaced condition string (for example "std.time-before").
+type Checker struct {
+	funcs map[string]Func
+}
+
+// New returns a Checker with no conditions registered.
+func New() *Checker {
+	return &Checker{
+		funcs: make(map[string]Func),
+	}
+}
+
+// RegisterFunc registers fn to check caveats with the given
+// condition in namespace ns. It panics if a checker is already
+// registered for that condition, to catch accidental collisions
+// early.
+func (c *Checker) RegisterFunc(ns, cond string, fn func(arg string) error) {
+	key := ns + "." + cond
+	if _, ok := c.funcs[key]; ok {
+		panic(fmt.Sprintf("checker already registered for condition %q", key))
+	}
+	c.funcs[key] = fn
+}
+
+// Register registers fn to check caveats with the given condition in
+// the standard namespace.
+func (c *Checker) Register(cond string, fn func(arg string) error) {
+	c.RegisterFunc(StdNamespace, cond, fn)
+}
+
+// Check checks that caveat is satisfied, splitting it into its
+// condition and argument on the first space. It returns an error if
+// no checker is registered for the caveat's condition, or if the
+// registered checker rejects the argument.
+func (c *Checker) Check(caveat string) error {
+	cond, arg := splitCaveat(caveat)
+	fn, ok := c.funcs[cond]
+	if !ok {
+		return fmt.Errorf("caveat %q not satisfied: unknown condition %q", caveat, cond)
+	}
+	if err := fn(arg); err != nil {
+		return fmt.Errorf("caveat %q not satisfied: %w", caveat, err)
+	}
+	return nil
+}
+
+// CheckCaveat adapts Check to the check function signature expected
+// by macaroon.Macaroon.Verify.
+func (c *Checker) CheckCaveat(caveat string) (bool, error) {
+	if err := c.Check(caveat); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// splitCaveat splits a caveat condition string into its condition
+// and argument, on the first space. If there is no space, the whole
+// string is the condition and arg is empty.
+func splitCaveat(caveat string) (cond, arg string) {
+	if i := strings.IndexByte(caveat, ' '); i >= 0 {
+		return caveat[:i], caveat[i+1:]
+	}
+	return caveat, ""
+}
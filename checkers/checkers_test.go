@@ -0,0 +1,38 @@
+package checkers
+
+import (
+	"errors"
+	"testing"
+)
+
+// needInteractionStub mirrors the shape of httpbakery's
+// NeedInteractionError closely enough to exercise the same failure
+// mode: a checker function returning a typed sentinel error that a
+// caller further up the stack needs to recover with errors.As.
+type needInteractionStub struct{ visitURL string }
+
+func (e *needInteractionStub) Error() string {
+	return "interaction required at " + e.visitURL
+}
+
+func TestCheckPreservesErrorType(t *testing.T) {
+	c := New()
+	want := &needInteractionStub{visitURL: "https://example.com/visit"}
+	c.Register("needs-interaction", func(arg string) error { return want })
+
+	err := c.Check("std.needs-interaction foo")
+	var got *needInteractionStub
+	if !errors.As(err, &got) {
+		t.Fatalf("errors.As could not recover the underlying error from %v", err)
+	}
+	if got != want {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestCheckUnknownCondition(t *testing.T) {
+	c := New()
+	if err := c.Check("std.nope arg"); err == nil {
+		t.Fatalf("expected an error for an unregistered condition")
+	}
+}
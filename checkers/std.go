@@ -0,0 +1,140 @@
+package checkers
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/rogpeppe/macaroon"
+)
+
+// StdContext carries the per-request information needed to evaluate
+// the standard checkers registered by Std.
+type StdContext struct {
+	// Declared holds the key/value pairs that std.declared caveats
+	// are expected to match. A std.declared caveat whose key is
+	// absent from Declared is treated as satisfied; this lets Infer
+	// be used to read back whatever was declared.
+	Declared map[string]string
+
+	// ClientIP holds the address of the client making the request,
+	// used to check std.ip caveats. It may be nil if unknown, in
+	// which case std.ip caveats always fail.
+	ClientIP net.IP
+
+	// Operation holds the operation the caller is attempting,
+	// checked against std.operation caveats.
+	Operation string
+}
+
+// Std returns a Checker with the standard checkers registered:
+// std.time-before, std.declared, std.ip and std.operation, evaluated
+// against ctx.
+func Std(ctx *StdContext) *Checker {
+	c := New()
+	c.Register(CondTimeBefore, CheckTimeBefore)
+	c.Register(CondDeclared, DeclaredChecker(ctx.Declared))
+	c.Register(CondClientIP, ClientIPChecker(ctx.ClientIP))
+	c.Register(CondOperation, OperationChecker(ctx.Operation))
+	return c
+}
+
+// CheckTimeBefore checks a std.time-before caveat, whose argument is
+// an RFC3339 timestamp. The caveat is satisfied as long as the
+// current time is before that timestamp.
+func CheckTimeBefore(arg string) error {
+	t, err := time.Parse(time.RFC3339, arg)
+	if err != nil {
+		return fmt.Errorf("invalid time-before timestamp %q: %v", arg, err)
+	}
+	if !time.Now().Before(t) {
+		return fmt.Errorf("macaroon has expired")
+	}
+	return nil
+}
+
+// DeclaredChecker returns a Func that checks a std.declared caveat,
+// whose argument has the form "key value". The caveat is satisfied
+// unless declared already holds a different value for key.
+func DeclaredChecker(declared map[string]string) Func {
+	return func(arg string) error {
+		key, value := splitCaveat(arg)
+		if key == "" {
+			return fmt.Errorf("declared caveat has no key")
+		}
+		if want, ok := declared[key]; ok && want != value {
+			return fmt.Errorf("declared %q does not match %q", value, want)
+		}
+		return nil
+	}
+}
+
+// ClientIPChecker returns a Func that checks a std.ip caveat, whose
+// argument is a CIDR-notation network. The caveat is satisfied if
+// clientIP falls within that network.
+func ClientIPChecker(clientIP net.IP) Func {
+	return func(arg string) error {
+		if clientIP == nil {
+			return fmt.Errorf("client IP address is not known")
+		}
+		_, ipNet, err := net.ParseCIDR(arg)
+		if err != nil {
+			if ip := net.ParseIP(arg); ip != nil {
+				if ip.Equal(clientIP) {
+					return nil
+				}
+				return fmt.Errorf("client IP %v does not match %v", clientIP, ip)
+			}
+			return fmt.Errorf("invalid ip caveat network %q: %v", arg, err)
+		}
+		if !ipNet.Contains(clientIP) {
+			return fmt.Errorf("client IP %v is not in %v", clientIP, ipNet)
+		}
+		return nil
+	}
+}
+
+// OperationChecker returns a Func that checks a std.operation
+// caveat, whose argument names the only operation the macaroon may
+// be used to authorize.
+func OperationChecker(op string) Func {
+	return func(arg string) error {
+		if arg != op {
+			return fmt.Errorf("macaroon not allowed for operation %q", op)
+		}
+		return nil
+	}
+}
+
+// Infer returns the key/value pairs declared by std.declared caveats
+// in m and its discharge macaroons. It does not check that m is
+// valid; it should only be called after Macaroon.Verify has
+// succeeded, so that the caveats can be trusted.
+func Infer(m *macaroon.Macaroon, discharges map[string]*macaroon.Macaroon) map[string]string {
+	declared := make(map[string]string)
+	inferDeclared(m, discharges, declared, make(map[*macaroon.Macaroon]bool))
+	return declared
+}
+
+func inferDeclared(m *macaroon.Macaroon, discharges map[string]*macaroon.Macaroon, declared map[string]string, seen map[*macaroon.Macaroon]bool) {
+	if seen[m] {
+		return
+	}
+	seen[m] = true
+	for _, cav := range m.Caveats() {
+		if cav.IsThirdParty() {
+			if dm, ok := discharges[cav.Id()]; ok {
+				inferDeclared(dm, discharges, declared, seen)
+			}
+			continue
+		}
+		cond, arg := splitCaveat(cav.Id())
+		if cond != StdNamespace+"."+CondDeclared {
+			continue
+		}
+		key, value := splitCaveat(arg)
+		if key != "" {
+			declared[key] = value
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package httpbakery
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/rogpeppe/macaroon/checkers"
+)
+
+// CheckerForRequest returns a checkers.Checker with the standard
+// checkers registered, using httpReq to supply the client's address
+// for std.ip caveats. The checker has no declared values, so every
+// std.declared caveat is satisfied, and no operation, so any
+// std.operation caveat that names an operation fails.
+func CheckerForRequest(httpReq *http.Request) *checkers.Checker {
+	return checkers.Std(&checkers.StdContext{
+		ClientIP: clientIP(httpReq),
+	})
+}
+
+func clientIP(httpReq *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(httpReq.RemoteAddr)
+	if err != nil {
+		return net.ParseIP(httpReq.RemoteAddr)
+	}
+	return net.ParseIP(host)
+}
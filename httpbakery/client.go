@@ -0,0 +1,188 @@
+package httpbakery
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/rogpeppe/macaroon"
+)
+
+// pollInterval is how long Client waits between polls of a
+// discharger's wait URL while an interactive discharge is in
+// progress.
+const pollInterval = time.Second
+
+// DischargeRequiredBody is the JSON body that a server should send,
+// along with a "WWW-Authenticate: Macaroon" header and a 401 status,
+// when a request fails because it lacks discharge macaroons for one
+// or more third-party caveats.
+type DischargeRequiredBody struct {
+	// Macaroon holds the macaroon that needs discharging.
+	Macaroon *macaroon.Macaroon
+}
+
+// Client wraps an http.Client so that Do transparently acquires and
+// attaches discharge macaroons when a request fails with a
+// discharge-required response, then retries it.
+type Client struct {
+	// Client is the underlying HTTP client used to make requests
+	// and to contact discharge locations. If nil, DefaultHTTPClient
+	// is used.
+	Client *http.Client
+
+	// VisitWebPage is called with a URL when a third party requires
+	// the user to interact with it (for example to log in) before
+	// it will discharge a caveat. If nil, interactive discharges
+	// fail with an error.
+	VisitWebPage func(visitURL string) error
+}
+
+// Do sends req using c's underlying client. If the response is a
+// discharge-required response, Do acquires a discharge macaroon for
+// each third-party caveat of the macaroon named in the response,
+// attaches all of them as cookies and resends the request.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !isDischargeRequired(resp) {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	var body DischargeRequiredBody
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal discharge-required response: %v", err)
+	}
+	if body.Macaroon == nil {
+		return nil, fmt.Errorf("discharge-required response did not include a macaroon")
+	}
+	discharges, err := c.dischargeAll(body.Macaroon)
+	if err != nil {
+		return nil, fmt.Errorf("cannot discharge macaroon: %v", err)
+	}
+	retryReq := cloneRequest(req)
+	if err := addCookie(retryReq, body.Macaroon); err != nil {
+		return nil, err
+	}
+	for _, dm := range discharges {
+		if err := addCookie(retryReq, dm); err != nil {
+			return nil, err
+		}
+	}
+	return c.httpClient().Do(retryReq)
+}
+
+func isDischargeRequired(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusUnauthorized &&
+		resp.Header.Get("WWW-Authenticate") == "Macaroon"
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.Client != nil {
+		return c.Client
+	}
+	return DefaultHTTPClient
+}
+
+// dischargeAll acquires a discharge macaroon for every third-party
+// caveat in m, binding each to m's signature as required before it
+// can be used alongside m.
+func (c *Client) dischargeAll(m *macaroon.Macaroon) ([]*macaroon.Macaroon, error) {
+	var discharges []*macaroon.Macaroon
+	for _, cav := range m.Caveats() {
+		if !cav.IsThirdParty() {
+			continue
+		}
+		dm, err := c.dischargeCaveat(&cav)
+		if err != nil {
+			return nil, fmt.Errorf("cannot discharge caveat for location %q: %v", cav.Location(), err)
+		}
+		dm = dm.Rebind(m.Signature())
+		discharges = append(discharges, dm)
+	}
+	return discharges, nil
+}
+
+// dischargeCaveat asks cav's location to discharge cav, following
+// the interactive-discharge flow if the third party requires it.
+func (c *Client) dischargeCaveat(cav *macaroon.Caveat) (*macaroon.Macaroon, error) {
+	values := url.Values{"id": {cav.Id()}}
+	resp, err := c.httpClient().PostForm(cav.Location()+"/discharge", values)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var m macaroon.Macaroon
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal discharge macaroon: %v", err)
+		}
+		return &m, nil
+	case http.StatusAccepted:
+		var info NeedInteractionError
+		if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal interaction-required response: %v", err)
+		}
+		return c.dischargeInteractive(&info, values)
+	default:
+		return nil, fmt.Errorf("discharge request failed with status %q", resp.Status)
+	}
+}
+
+// dischargeInteractive sends the user to info.VisitURL and then
+// polls info.WaitURL until the discharge completes.
+func (c *Client) dischargeInteractive(info *NeedInteractionError, values url.Values) (*macaroon.Macaroon, error) {
+	if c.VisitWebPage == nil {
+		return nil, fmt.Errorf("interaction required but no VisitWebPage callback is set")
+	}
+	if err := c.VisitWebPage(info.VisitURL); err != nil {
+		return nil, fmt.Errorf("cannot visit %q: %v", info.VisitURL, err)
+	}
+	for {
+		resp, err := c.httpClient().PostForm(info.WaitURL, values)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusAccepted {
+			resp.Body.Close()
+			time.Sleep(pollInterval)
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("discharge wait failed with status %q", resp.Status)
+		}
+		var m macaroon.Macaroon
+		if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+			return nil, fmt.Errorf("cannot unmarshal discharge macaroon: %v", err)
+		}
+		return &m, nil
+	}
+}
+
+func cloneRequest(req *http.Request) *http.Request {
+	r := *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	return &r
+}
+
+func addCookie(req *http.Request, m *macaroon.Macaroon) error {
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("cannot marshal macaroon for cookie: %v", err)
+	}
+	req.AddCookie(&http.Cookie{
+		Name:  "macaroon-" + m.Id(),
+		Value: base64.StdEncoding.EncodeToString(data),
+	})
+	return nil
+}
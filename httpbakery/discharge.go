@@ -0,0 +1,72 @@
+package httpbakery
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/rogpeppe/macaroon/bakery"
+)
+
+// NeedInteractionError is returned by a bakery.ThirdPartyChecker (or
+// surfaces through a bakery.Discharger's Checker) to indicate that
+// the caveat can't be discharged until the user interacts with the
+// third party directly, for example to log in.
+//
+// DischargeHandler turns this into a 202 response carrying VisitURL
+// and WaitURL; Client.Do opens VisitURL (via VisitWebPage) and then
+// polls WaitURL until the discharge completes.
+type NeedInteractionError struct {
+	// VisitURL is the URL the user should visit to complete the
+	// interaction, typically in a browser.
+	VisitURL string
+
+	// WaitURL is the URL the client should poll, using the same
+	// parameters as the original discharge request, until the
+	// interaction is complete and the discharge macaroon is ready.
+	WaitURL string
+}
+
+func (e *NeedInteractionError) Error() string {
+	return fmt.Sprintf("interaction at %s required to discharge caveat", e.VisitURL)
+}
+
+// DischargeHandler serves third-party discharge requests on behalf
+// of a bakery.Discharger. It should be registered at the location
+// ("/discharge") that AddThirdPartyCaveat used for this service.
+type DischargeHandler struct {
+	Discharger *bakery.Discharger
+}
+
+// ServeHTTP implements http.Handler. It expects the caveat id in the
+// "id" form value, and responds with the discharge macaroon as a
+// JSON body, or a 202 response carrying a NeedInteractionError if
+// the underlying checker requires the user to interact with it
+// first, or a 403 response if discharge is refused outright.
+func (h *DischargeHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id := req.Form.Get("id")
+	if id == "" {
+		http.Error(w, "no caveat id specified", http.StatusBadRequest)
+		return
+	}
+	m, err := h.Discharger.Discharge(id)
+	if err != nil {
+		var niErr *NeedInteractionError
+		if errors.As(err, &niErr) {
+			w.WriteHeader(http.StatusAccepted)
+			json.NewEncoder(w).Encode(niErr)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(m); err != nil {
+		log.Printf("cannot encode discharge macaroon: %v", err)
+	}
+}
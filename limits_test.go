@@ -0,0 +1,47 @@
+package macaroon
+
+import "testing"
+
+func TestUnmarshalJSONRejectsTooManyCaveats(t *testing.T) {
+	saved := MaxCaveats
+	MaxCaveats = 2
+	defer func() { MaxCaveats = saved }()
+
+	data := []byte(`{"location":"","identifier":"id","signature":"00","caveats":[{"cid":"a","location":"","vid":""},{"cid":"b","location":"","vid":""},{"cid":"c","location":"","vid":""}]}`)
+	var m Macaroon
+	if err := m.UnmarshalJSON(data); err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestUnmarshalBinaryRejectsTooManyCaveats(t *testing.T) {
+	m := newTestMacaroon(t)
+	m.addCaveat("extra", nil, "")
+	data, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := MaxCaveats
+	MaxCaveats = 1
+	defer func() { MaxCaveats = saved }()
+	var m1 Macaroon
+	if err := m1.UnmarshalBinary(data); err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+}
+
+func TestUnmarshalBinaryV2RejectsTooManyCaveats(t *testing.T) {
+	m := newTestMacaroon(t)
+	m.addCaveat("extra", nil, "")
+	data, err := m.MarshalBinaryV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := MaxCaveats
+	MaxCaveats = 1
+	defer func() { MaxCaveats = saved }()
+	var m1 Macaroon
+	if err := m1.UnmarshalBinaryV2(data); err != ErrTooLarge {
+		t.Fatalf("got %v, want ErrTooLarge", err)
+	}
+}
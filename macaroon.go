@@ -14,6 +14,7 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 )
 
@@ -21,12 +22,23 @@ import (
 // See Fig. 7 of http://theory.stanford.edu/~ataly/Papers/macaroons.pdf
 // for a description of the data contained within.
 // Macaroons are mutable objects - use Clone as appropriate
-// to avoid unwanted mutation.
+// to avoid unwanted mutation. Prefer Builder and Rebind, which build
+// and bind macaroons without mutating an existing value, over the
+// deprecated mutating methods below.
 type Macaroon struct {
 	location string
 	id       string
 	caveats  []Caveat
 	sig      []byte
+
+	// sigHistory holds the signature m had after each prefix of its
+	// caveats were added, with sigHistory[0] the signature before
+	// any caveats at all. It lets Prepare rewind caveats without the
+	// root key. It's only populated by New, addCaveat and Clone; a
+	// Macaroon reconstructed by an Unmarshal method has none of it,
+	// since the root key needed to have produced it is long gone by
+	// then.
+	sigHistory [][]byte
 }
 
 // Caveat holds a first person or third party caveat.
@@ -36,8 +48,14 @@ type Caveat struct {
 	verificationId []byte
 }
 
+// Version1 is the MacaroonDoc version written by MarshalDoc.
+// UnmarshalDoc also accepts the zero value, for documents produced
+// before versioning was introduced.
+const Version1 = 1
+
 // MacaroonDoc defines a serializable document for macaroons.
 type MacaroonDoc struct {
+	Version    int      `json:"version,omitempty"`
 	Caveats    []Caveat `json:"caveats"`
 	Location   string   `json:"location"`
 	Identifier string   `json:"identifier"`
@@ -51,6 +69,37 @@ type CaveatDoc struct {
 	VID      string `json:"vid"`
 }
 
+// Limits on the fields accepted by UnmarshalJSON and UnmarshalDoc,
+// guarding against a peer handing over a macaroon with implausibly
+// large fields or caveat counts. These are variables, not constants,
+// so that applications that legitimately need larger macaroons can
+// raise them.
+var (
+	MaxFieldLen = 32 * 1024
+	MaxCaveats  = 128
+)
+
+var (
+	// ErrBadVersion is returned when a MacaroonDoc specifies a
+	// document version that this package does not understand.
+	ErrBadVersion = errors.New("macaroon: unsupported document version")
+
+	// ErrTooLarge is returned when a field, or the number of
+	// caveats, in a MacaroonDoc exceeds the configured limits.
+	ErrTooLarge = errors.New("macaroon: field exceeds maximum size")
+
+	// ErrBadSignature is returned when a macaroon's signature does
+	// not decode to the length of a SHA-256 HMAC.
+	ErrBadSignature = errors.New("macaroon: signature has wrong length")
+)
+
+func checkFieldLen(value string) error {
+	if len(value) > MaxFieldLen {
+		return ErrTooLarge
+	}
+	return nil
+}
+
 func (cav *Caveat) MarshalDoc() *CaveatDoc {
 	return &CaveatDoc{
 		Location: cav.location,
@@ -73,12 +122,21 @@ func (cav *Caveat) UnmarshalJSON(jsonData []byte) error {
 	var doc CaveatDoc
 	err := json.Unmarshal(jsonData, &doc)
 	if err != nil {
-		return fmt.Errorf("cannot unmarshal json data: %v", doc.CID, err)
+		return fmt.Errorf("cannot unmarshal json data: %v", err)
 	}
 	return cav.UnmarshalDoc(&doc)
 }
 
 func (cav *Caveat) UnmarshalDoc(doc *CaveatDoc) error {
+	if err := checkFieldLen(doc.Location); err != nil {
+		return err
+	}
+	if err := checkFieldLen(doc.CID); err != nil {
+		return err
+	}
+	if err := checkFieldLen(doc.VID); err != nil {
+		return err
+	}
 	var err error
 	cav.location = doc.Location
 	cav.caveatId = doc.CID
@@ -95,6 +153,21 @@ func (cav *Caveat) IsThirdParty() bool {
 	return len(cav.verificationId) > 0
 }
 
+// Id returns the caveat's id: for a first-party caveat, this is the
+// condition string that Macaroon.Verify passes to the check
+// function; for a third-party caveat, it's the opaque id used to
+// look up the discharge macaroon.
+func (cav *Caveat) Id() string {
+	return cav.caveatId
+}
+
+// Location returns the caveat's location hint, as given to
+// AddThirdPartyCaveat. It's only meaningful for third-party caveats,
+// and it isn't verified as part of the macaroon.
+func (cav *Caveat) Location() string {
+	return cav.location
+}
+
 // New returns a new macaroon with the given root key,
 // identifier and location.
 func New(rootKey []byte, id, loc string) *Macaroon {
@@ -103,6 +176,7 @@ func New(rootKey []byte, id, loc string) *Macaroon {
 		id:       id,
 	}
 	m.sig = keyedHash(rootKey, m.id)
+	m.sigHistory = [][]byte{append([]byte(nil), m.sig...)}
 	return m
 }
 
@@ -111,6 +185,12 @@ func (m *Macaroon) Clone() *Macaroon {
 	m1 := *m
 	m1.caveats = make([]Caveat, len(m.caveats))
 	copy(m1.caveats, m.caveats)
+	if m.sigHistory != nil {
+		m1.sigHistory = make([][]byte, len(m.sigHistory))
+		for i, sig := range m.sigHistory {
+			m1.sigHistory[i] = append([]byte(nil), sig...)
+		}
+	}
 	return &m1
 }
 
@@ -147,17 +227,59 @@ func (m *Macaroon) addCaveat(caveatId string, verificationId []byte, loc string)
 	sig.Write(verificationId)
 	sig.Write([]byte(caveatId))
 	m.sig = sig.Sum(nil)
+	if m.sigHistory != nil {
+		m.sigHistory = append(m.sigHistory, append([]byte(nil), m.sig...))
+	}
+}
+
+// Prepare returns a copy of m with only its first n caveats, as if
+// its Builder had stopped after adding the nth one. It lets a caller
+// back out caveats it speculatively added without needing the root
+// key to re-derive the macaroon from scratch.
+//
+// It returns an error if n is negative or greater than the number of
+// caveats m has, or if m doesn't carry the signature history needed
+// to rewind - which is only true of a macaroon produced by New,
+// Clone or a Builder, since a macaroon reconstructed by an Unmarshal
+// method never had the root key needed to produce it.
+func (m *Macaroon) Prepare(n int) (*Macaroon, error) {
+	if n < 0 || n > len(m.caveats) {
+		return nil, fmt.Errorf("macaroon: cannot prepare %d caveats, have %d", n, len(m.caveats))
+	}
+	if len(m.sigHistory) != len(m.caveats)+1 {
+		return nil, fmt.Errorf("macaroon: macaroon has no signature history to prepare from")
+	}
+	m1 := m.Clone()
+	m1.caveats = append([]Caveat(nil), m.caveats[:n]...)
+	m1.sigHistory = append([][]byte(nil), m.sigHistory[:n+1]...)
+	m1.sig = append([]byte(nil), m1.sigHistory[n]...)
+	return m1, nil
 }
 
 // Bind prepares the macaroon for being used to discharge the
 // macaroon with the given rootSig. This must be
 // used before it is used in the discharges argument to Verify.
+//
+// Deprecated: Bind mutates the receiver, which can corrupt a
+// macaroon shared with another caller. Use Rebind instead.
 func (m *Macaroon) Bind(rootSig []byte) {
 	m.sig = bindForRequest(rootSig, m.sig)
 }
 
+// Rebind returns a copy of m bound for use as a discharge macaroon
+// for the macaroon whose signature is rootSig, leaving m itself
+// unchanged. It's the non-mutating equivalent of Bind.
+func (m *Macaroon) Rebind(rootSig []byte) *Macaroon {
+	m1 := m.Clone()
+	m1.sig = bindForRequest(rootSig, m.sig)
+	return m1
+}
+
 // AddFirstPartyCaveat adds a caveat that will be verified
 // by the target service.
+//
+// Deprecated: AddFirstPartyCaveat mutates the receiver, which can
+// corrupt a macaroon shared with another caller. Use Builder instead.
 func (m *Macaroon) AddFirstPartyCaveat(caveatId string) {
 	m.addCaveat(caveatId, nil, "")
 }
@@ -190,6 +312,9 @@ func DecryptThirdPartyCaveatId(secret []byte, id string) (*ThirdPartyCaveatId, e
 // AddThirdPartyCaveat adds a third-party caveat to the macaroon,
 // using the given shared secret, caveat and location hint.
 // It returns the caveat id of the third party macaroon.
+//
+// Deprecated: AddThirdPartyCaveat mutates the receiver, which can
+// corrupt a macaroon shared with another caller. Use Builder instead.
 func (m *Macaroon) AddThirdPartyCaveat(thirdPartySecret []byte, caveat string, loc string) (id string, err error) {
 	nonce, err := newNonce()
 	if err != nil {
@@ -280,6 +405,7 @@ func (m *Macaroon) verify(rootSig []byte, rootKey []byte, check func(caveat stri
 
 func (m *Macaroon) MarshalDoc() *MacaroonDoc {
 	return &MacaroonDoc{
+		Version:    Version1,
 		Location:   m.Location(),
 		Identifier: m.id,
 		Signature:  hex.EncodeToString(m.sig),
@@ -296,25 +422,65 @@ func (m *Macaroon) MarshalJSON() ([]byte, error) {
 	return data, nil
 }
 
-// UnmarshalJSON implements json.Unmarshaler.
+// probeDoc mirrors the fields of MacaroonDoc that UnmarshalJSON needs
+// to check before it's worth paying for a full decode, leaving the
+// caveats themselves as raw JSON so that checking their count
+// doesn't also pay for decoding each one.
+type probeDoc struct {
+	Version int               `json:"version,omitempty"`
+	Caveats []json.RawMessage `json:"caveats"`
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It checks the
+// document's version and caveat count before doing a full decode, so
+// that a document with an implausible number of caveats is rejected
+// without the cost of decoding (and hex-checking) every one of them.
 func (m *Macaroon) UnmarshalJSON(jsonData []byte) error {
+	var probe probeDoc
+	if err := json.Unmarshal(jsonData, &probe); err != nil {
+		return fmt.Errorf("cannot unmarshal json data: %v", err)
+	}
+	if probe.Version != 0 && probe.Version != Version1 {
+		return ErrBadVersion
+	}
+	if len(probe.Caveats) > MaxCaveats {
+		return ErrTooLarge
+	}
 	var mjson MacaroonDoc
-	err := json.Unmarshal(jsonData, &mjson)
-	if err != nil {
+	if err := json.Unmarshal(jsonData, &mjson); err != nil {
 		return fmt.Errorf("cannot unmarshal json data: %v", err)
 	}
 	return m.UnmarshalDoc(&mjson)
 }
 
 func (m *Macaroon) UnmarshalDoc(doc *MacaroonDoc) error {
+	if doc.Version != 0 && doc.Version != Version1 {
+		return ErrBadVersion
+	}
+	if len(doc.Caveats) > MaxCaveats {
+		return ErrTooLarge
+	}
+	if err := checkFieldLen(doc.Location); err != nil {
+		return err
+	}
+	if err := checkFieldLen(doc.Identifier); err != nil {
+		return err
+	}
+	if err := checkFieldLen(doc.Signature); err != nil {
+		return err
+	}
 	var err error
 	m.location = doc.Location
 	m.id = doc.Identifier
 	m.sig, err = hex.DecodeString(doc.Signature)
 	if err != nil {
-		return fmt.Errorf("cannot decode macaroon signature %q: %v", m.sig, err)
+		return fmt.Errorf("cannot decode macaroon signature %q: %v", doc.Signature, err)
+	}
+	if len(m.sig) != sha256.Size {
+		return ErrBadSignature
 	}
 	m.caveats = doc.Caveats
+	m.sigHistory = nil
 	return nil
 }
 
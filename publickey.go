@@ -0,0 +1,113 @@
+package macaroon
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+
+	"golang.org/x/crypto/nacl/box"
+)
+
+// KeyPair holds a Curve25519 key pair, used with
+// AddThirdPartyCaveatWithKey and DecryptThirdPartyCaveatIdWithKey to
+// set up third-party caveats without the first party and the third
+// party needing to share a symmetric secret in advance - the first
+// party only needs to know the third party's public key.
+type KeyPair struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// GenerateKeyPair returns a newly generated KeyPair.
+func GenerateKeyPair() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate key pair: %v", err)
+	}
+	return &KeyPair{Public: *pub, Private: *priv}, nil
+}
+
+// thirdPartyCaveatIdVersionBox marks a caveat id produced by
+// AddThirdPartyCaveatWithKey, as opposed to the pre-shared-secret
+// format produced by AddThirdPartyCaveat, which carries no such tag.
+// Keeping it as an explicit byte in the ciphertext leaves room for
+// future caveat id encodings to be added the same way.
+const thirdPartyCaveatIdVersionBox = 1
+
+const boxNonceLen = 24
+
+// AddThirdPartyCaveatWithKey is like AddThirdPartyCaveat, but instead
+// of a secret shared with the third party in advance, it uses
+// public-key cryptography: theirPub is the third party's public key,
+// and myKey is the first party's own key pair. Only the holder of
+// the private key matching theirPub can recover the root key and
+// condition, via DecryptThirdPartyCaveatIdWithKey.
+//
+// Deprecated: AddThirdPartyCaveatWithKey mutates the receiver, which
+// can corrupt a macaroon shared with another caller. Use Builder
+// instead.
+func (m *Macaroon) AddThirdPartyCaveatWithKey(theirPub *[32]byte, myKey *KeyPair, condition string, loc string) (id string, err error) {
+	rootKey := make([]byte, boxNonceLen)
+	if _, err := rand.Read(rootKey); err != nil {
+		return "", fmt.Errorf("cannot generate root key: %v", err)
+	}
+	var nonce [boxNonceLen]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return "", fmt.Errorf("cannot generate nonce: %v", err)
+	}
+	plain, err := json.Marshal(ThirdPartyCaveatId{rootKey, condition})
+	if err != nil {
+		return "", err
+	}
+	sealed := box.Seal(nil, plain, &nonce, theirPub, &myKey.Private)
+
+	data := make([]byte, 0, 1+len(myKey.Public)+boxNonceLen+len(sealed))
+	data = append(data, thirdPartyCaveatIdVersionBox)
+	data = append(data, myKey.Public[:]...)
+	data = append(data, nonce[:]...)
+	data = append(data, sealed...)
+
+	verificationId, err := encrypt(m.sig, rootKey)
+	if err != nil {
+		return "", err
+	}
+	encCaveatId := base64.StdEncoding.EncodeToString(data)
+	m.addCaveat(encCaveatId, verificationId, loc)
+	return encCaveatId, nil
+}
+
+// DecryptThirdPartyCaveatIdWithKey decrypts a third-party caveat id
+// created by AddThirdPartyCaveatWithKey, using the recipient's own
+// key pair, myKey. Unlike DecryptThirdPartyCaveatId, the discharger
+// doesn't need to know anything about the caveat's creator in
+// advance beyond its public key, which travels with the id.
+func DecryptThirdPartyCaveatIdWithKey(myKey *KeyPair, id string) (*ThirdPartyCaveatId, error) {
+	data, err := base64.StdEncoding.DecodeString(id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot base64-decode caveat id: %v", err)
+	}
+	if len(data) < 1+len(myKey.Public)+boxNonceLen {
+		return nil, fmt.Errorf("caveat id is too short")
+	}
+	if data[0] != thirdPartyCaveatIdVersionBox {
+		return nil, fmt.Errorf("caveat id has unexpected version %d", data[0])
+	}
+	data = data[1:]
+	var theirPub [32]byte
+	copy(theirPub[:], data[:len(theirPub)])
+	data = data[len(theirPub):]
+	var nonce [boxNonceLen]byte
+	copy(nonce[:], data[:boxNonceLen])
+	sealed := data[boxNonceLen:]
+
+	plain, ok := box.Open(nil, sealed, &nonce, &theirPub, &myKey.Private)
+	if !ok {
+		return nil, fmt.Errorf("cannot decrypt third party caveat id")
+	}
+	var c ThirdPartyCaveatId
+	if err := json.Unmarshal(plain, &c); err != nil {
+		return nil, fmt.Errorf("cannot unmarshal decrypted caveat id: %v", err)
+	}
+	return &c, nil
+}
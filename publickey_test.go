@@ -0,0 +1,74 @@
+package macaroon
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestAddThirdPartyCaveatWithKeyRoundTrip(t *testing.T) {
+	myKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair (first party): %v", err)
+	}
+	theirKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatalf("GenerateKeyPair (third party): %v", err)
+	}
+
+	m := New([]byte("root key"), "some id", "http://example.com/")
+	id, err := m.AddThirdPartyCaveatWithKey(&theirKey.Public, myKey, "user = alice", "http://auth.example.com/")
+	if err != nil {
+		t.Fatalf("AddThirdPartyCaveatWithKey: %v", err)
+	}
+
+	tpid, err := DecryptThirdPartyCaveatIdWithKey(theirKey, id)
+	if err != nil {
+		t.Fatalf("DecryptThirdPartyCaveatIdWithKey: %v", err)
+	}
+	if tpid.Caveat != "user = alice" {
+		t.Errorf("condition: got %q want %q", tpid.Caveat, "user = alice")
+	}
+	if len(tpid.RootKey) != boxNonceLen {
+		t.Errorf("root key length: got %d want %d", len(tpid.RootKey), boxNonceLen)
+	}
+}
+
+func TestDecryptThirdPartyCaveatIdWithKeyWrongKey(t *testing.T) {
+	myKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	theirKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := New([]byte("root key"), "some id", "http://example.com/")
+	id, err := m.AddThirdPartyCaveatWithKey(&theirKey.Public, myKey, "user = alice", "http://auth.example.com/")
+	if err != nil {
+		t.Fatalf("AddThirdPartyCaveatWithKey: %v", err)
+	}
+
+	if _, err := DecryptThirdPartyCaveatIdWithKey(wrongKey, id); err == nil {
+		t.Fatalf("expected decryption to fail with the wrong key")
+	}
+}
+
+func TestDecryptThirdPartyCaveatIdWithKeyRejectsBadVersion(t *testing.T) {
+	theirKey, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Long enough to pass the length check, so that the version byte
+	// is what gets rejected.
+	badData := make([]byte, 1+len(theirKey.Public)+boxNonceLen)
+	badData[0] = thirdPartyCaveatIdVersionBox + 1
+	badId := base64.StdEncoding.EncodeToString(badData)
+	if _, err := DecryptThirdPartyCaveatIdWithKey(theirKey, badId); err == nil {
+		t.Fatalf("expected an error for an unrecognised version byte")
+	}
+}